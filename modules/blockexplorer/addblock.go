@@ -38,9 +38,18 @@ func addHashType(tx *bolt.Tx, hash crypto.Hash, hashType int) error {
 	return putObject(b, hash, hashType)
 }
 
-// addAddress either creates a new list of transactions for the given
-// address, or adds the txid to the list if such a list already exists
-func addAddress(tx *bolt.Tx, addr types.UnlockHash, txid crypto.Hash) error {
+// addAddress records that txid touched addr at the given height and
+// transaction index, creating a per-address bucket the first time the
+// address is seen. Keying each entry by (height, txIndex, txid) rather
+// than appending to a single stored slice makes the write O(log n)
+// instead of O(n), even for addresses with millions of transactions.
+//
+// A single transaction can touch the same address more than once (two
+// outputs paying the same UnlockHash, say), which collapses onto the
+// same key. The value is therefore a refcount rather than an empty
+// entry, so that an equal number of addAddress/removeAddress calls
+// during revert always leaves the key in the state it started in.
+func addAddress(tx *bolt.Tx, addr types.UnlockHash, height types.BlockHeight, txIndex int, txid crypto.Hash) error {
 	err := addHashType(tx, crypto.Hash(addr), hashUnlockHash)
 	if err != nil {
 		return err
@@ -51,14 +60,22 @@ func addAddress(tx *bolt.Tx, addr types.UnlockHash, txid crypto.Hash) error {
 		return errors.New("Addresses bucket does not exist")
 	}
 
-	var txns []crypto.Hash
-	err = getObject(b, addr, &txns)
-	if err != ErrNilEntry {
+	addrBucket, err := b.CreateBucketIfNotExists(encoding.Marshal(addr))
+	if err != nil {
 		return err
 	}
-	txns = append(txns, txid)
 
-	return putObject(b, addr, txns)
+	key := addressKey(height, txIndex, txid)
+	var refCount uint64
+	if entry := addrBucket.Get(key); entry != nil {
+		err = encoding.Unmarshal(entry, &refCount)
+		if err != nil {
+			return err
+		}
+	}
+	refCount++
+
+	return addrBucket.Put(key, encoding.Marshal(refCount))
 }
 
 // addSiacoinInput changes an existing outputTransactions struct to
@@ -161,79 +178,74 @@ func addNewSFOutput(tx *bolt.Tx, outputID types.SiafundOutputID, txid crypto.Has
 	return addNewHash(tx, "SiafundOutputs", hashFundOutputID, crypto.Hash(outputID), otx)
 }
 
+// explorerBlockSummary builds the modules.ExplorerBlockData recorded
+// for b in the Heights bucket.
+func explorerBlockSummary(b types.Block, blocktarget types.Target) modules.ExplorerBlockData {
+	return modules.ExplorerBlockData{
+		ID:        b.ID(),
+		Timestamp: b.Timestamp,
+		Target:    blocktarget,
+		Size:      uint64(len(encoding.Marshal(b))),
+	}
+}
+
 // addHeight adds a block summary (modules.ExplorerBlockData) to the
-// database with a height as the key
+// database, keyed by the big-endian encoding of height so that the
+// bucket can be range-scanned in height order with Cursor.Seek.
 func addHeight(tx *bolt.Tx, height types.BlockHeight, bs modules.ExplorerBlockData) error {
 	b := tx.Bucket([]byte("Heights"))
 	if b == nil {
 		return errors.New("bucket Blocks does not exist")
 	}
 
-	return putObject(b, height, bs)
+	return b.Put(heightKey(height), encoding.Marshal(bs))
 }
 
-// addBlockDB parses a block and adds it to the database
-func (be *BlockExplorer) addBlockDB(b types.Block) error {
-	// Special case for the genesis block, which does not have a
-	// valid parent, and for testing, as tests will not always use
-	// blocks in consensus
-	var blocktarget types.Target
+// childTarget returns the target that the given block was mined
+// against, special-casing the genesis block and testing blocks that
+// were never submitted through consensus.
+func (be *BlockExplorer) childTarget(b types.Block) types.Target {
 	if b.ID() == be.genesisBlockID {
-		blocktarget = types.RootDepth
-	} else {
-		var exists bool
-		blocktarget, exists = be.cs.ChildTarget(b.ParentID)
-		if build.DEBUG {
-			if build.Release == "testing" {
-				blocktarget = types.RootDepth
-			}
-			if !exists {
-				panic("Applied block not in consensus")
-			}
-
-		}
-	}
-
-	tx, err := be.db.Begin(true)
-	if err != nil {
-		return err
+		return types.RootDepth
 	}
-	defer tx.Rollback()
 
-	// Construct the struct that will be inside the database
-	blockStruct := blockData{
-		Block:  b,
-		Height: be.blockchainHeight,
+	blocktarget, exists := be.cs.ChildTarget(b.ParentID)
+	if build.DEBUG {
+		if build.Release == "testing" {
+			return types.RootDepth
+		}
+		if !exists {
+			panic("Applied block not in consensus")
+		}
 	}
+	return blocktarget
+}
 
-	err = addNewHash(tx, "Blocks", hashBlock, crypto.Hash(b.ID()), blockStruct)
-	if err != nil {
-		return err
-	}
+// applyBlockDB parses a block and adds it to the database, using the
+// given transaction rather than opening one of its own so that it can
+// be composed with the rest of a consensus change.
+func (be *BlockExplorer) applyBlockDB(tx *bolt.Tx, b types.Block) error {
+	blocktarget := be.childTarget(b)
+	store := newBoltStore(tx)
 
-	bSum := modules.ExplorerBlockData{
-		ID:        b.ID(),
-		Timestamp: b.Timestamp,
-		Target:    blocktarget,
-		Size:      uint64(len(encoding.Marshal(b))),
-	}
-
-	err = addHeight(tx, be.blockchainHeight, bSum)
-	if err != nil {
-		return err
-	}
-	err = addHashType(tx, crypto.Hash(b.ID()), hashBlock)
+	err := store.PutBlock(be.blockchainHeight, b, blocktarget)
 	if err != nil {
 		return err
 	}
 
-	// Insert the miner payouts as new outputs
+	// Insert the miner payouts as new outputs. Miner payouts have no
+	// transaction of their own, so they are indexed under the
+	// reserved minerPayoutTxIndex.
 	for i, payout := range b.MinerPayouts {
-		err = addAddress(tx, payout.UnlockHash, crypto.Hash(b.ID()))
+		err = store.AppendAddressTxn(payout.UnlockHash, be.blockchainHeight, minerPayoutTxIndex, crypto.Hash(b.ID()))
+		if err != nil {
+			return err
+		}
+		err = store.PutSiacoinOutput(b.MinerPayoutID(i), crypto.Hash(b.ID()))
 		if err != nil {
 			return err
 		}
-		err = addNewOutput(tx, b.MinerPayoutID(i), crypto.Hash(b.ID()))
+		err = store.PutMaturingPayout(be.blockchainHeight+types.MaturityDelay, crypto.Hash(b.MinerPayoutID(i)))
 		if err != nil {
 			return err
 		}
@@ -241,28 +253,74 @@ func (be *BlockExplorer) addBlockDB(b types.Block) error {
 
 	// Insert each transaction
 	for i, txn := range b.Transactions {
-		err = addNewHash(tx, "Transactions", hashTransaction, txn.ID(), txInfo{b.ID(), i})
+		err = store.PutTransaction(be.blockchainHeight, i, b.ID(), txn)
 		if err != nil {
 			return err
 		}
-		err = be.addTransaction(tx, txn)
+		err = be.addTransaction(store, tx, be.blockchainHeight, i, txn)
 		if err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
+	resolved, err := be.resolveExpiredContracts(tx, be.blockchainHeight)
+	if err != nil {
+		return err
+	}
+	err = putResolvedAtHeight(tx, be.blockchainHeight, resolved)
+	if err != nil {
+		return err
+	}
+
+	return be.addBlockFacts(store, tx, b, blocktarget)
+}
+
+// addBlockFacts updates the running BlockFacts counters with the
+// contracts and revisions in b, then persists the resulting
+// modules.BlockFacts record for be.blockchainHeight through store.
+func (be *BlockExplorer) addBlockFacts(store ExplorerStore, tx *bolt.Tx, b types.Block, blocktarget types.Target) error {
+	bfi, err := getBlockFactsInternal(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, txn := range b.Transactions {
+		for _, fc := range txn.FileContracts {
+			addFcFacts(&bfi, fc)
+		}
+		for _, fcr := range txn.FileContractRevisions {
+			addFcRevisionFacts(&bfi, fcr)
+		}
+	}
+
+	facts, err := buildBlockFacts(tx, b, be.blockchainHeight, blocktarget, bfi)
+	if err != nil {
+		return err
+	}
+
+	err = store.PutBlockFacts(be.blockchainHeight, facts)
+	if err != nil {
+		return err
+	}
+
+	return putBlockFactsInternal(tx, bfi)
 }
 
-// addTransaction is called from addBlockDB, and delegates the adding
-// of information to the database to the functions defined above
-func (be *BlockExplorer) addTransaction(btx *bolt.Tx, tx types.Transaction) error {
+// addTransaction is called from applyBlockDB, and delegates the adding
+// of information to the database to store and the functions defined
+// above. height and txIndex identify tx's position in the chain, and
+// are threaded through to store.AppendAddressTxn so that each
+// address's transaction history stays ordered without an O(n)
+// read-modify-write. btx is still needed alongside store for the
+// contract-lifecycle bookkeeping (reviseContract, resolveContractProof)
+// that falls outside ExplorerStore's write set.
+func (be *BlockExplorer) addTransaction(store ExplorerStore, btx *bolt.Tx, height types.BlockHeight, txIndex int, tx types.Transaction) error {
 	// Store this for quick lookup
 	txid := tx.ID()
 
 	// Append each input to the list of modifications
 	for _, input := range tx.SiacoinInputs {
-		err := addSiacoinInput(btx, input.ParentID, txid)
+		err := store.SetOutputSpender(crypto.Hash(input.ParentID), txid)
 		if err != nil {
 			return err
 		}
@@ -270,11 +328,11 @@ func (be *BlockExplorer) addTransaction(btx *bolt.Tx, tx types.Transaction) erro
 
 	// Handle all the transaction outputs
 	for i, output := range tx.SiacoinOutputs {
-		err := addAddress(btx, output.UnlockHash, txid)
+		err := store.AppendAddressTxn(output.UnlockHash, height, txIndex, txid)
 		if err != nil {
 			return err
 		}
-		err = addNewOutput(btx, tx.SiacoinOutputID(i), txid)
+		err = store.PutSiacoinOutput(tx.SiacoinOutputID(i), txid)
 		if err != nil {
 			return err
 		}
@@ -289,29 +347,33 @@ func (be *BlockExplorer) addTransaction(btx *bolt.Tx, tx types.Transaction) erro
 		if err != nil {
 			return err
 		}
+		err = store.PutContract(fcid, contract)
+		if err != nil {
+			return err
+		}
 
 		for j, output := range contract.ValidProofOutputs {
-			err = addAddress(btx, output.UnlockHash, txid)
+			err = store.AppendAddressTxn(output.UnlockHash, height, txIndex, txid)
 			if err != nil {
 				return err
 			}
-			err = addNewOutput(btx, fcid.StorageProofOutputID(true, j), txid)
+			err = store.PutSiacoinOutput(fcid.StorageProofOutputID(true, j), txid)
 			if err != nil {
 				return err
 			}
 		}
 		for j, output := range contract.MissedProofOutputs {
-			err = addAddress(btx, output.UnlockHash, txid)
+			err = store.AppendAddressTxn(output.UnlockHash, height, txIndex, txid)
 			if err != nil {
 				return err
 			}
-			err = addNewOutput(btx, fcid.StorageProofOutputID(false, j), txid)
+			err = store.PutSiacoinOutput(fcid.StorageProofOutputID(false, j), txid)
 			if err != nil {
 				return err
 			}
 		}
 
-		err = addAddress(btx, contract.UnlockHash, txid)
+		err = store.AppendAddressTxn(contract.UnlockHash, height, txIndex, txid)
 		if err != nil {
 			return err
 		}
@@ -319,41 +381,55 @@ func (be *BlockExplorer) addTransaction(btx *bolt.Tx, tx types.Transaction) erro
 
 	// Update the list of revisions
 	for _, revision := range tx.FileContractRevisions {
-		err := addFcRevision(btx, revision.ParentID, txid)
+		err := store.AppendContractRevision(revision.ParentID, txid)
+		if err != nil {
+			return err
+		}
+		err = reviseContract(btx, revision.ParentID, revision)
 		if err != nil {
 			return err
 		}
 
-		// Note the old outputs will still be there in the
-		// database. This is to provide information to the
-		// people who may just need it.
+		// A revision's proof outputs have the same IDs as the
+		// contract's (both derive from ParentID and the output
+		// index), so PutSiacoinOutput below repoints those entries
+		// at this revision's txid rather than adding new ones;
+		// reviseContract above keeps the contract's *current* payout
+		// outputs and proof window in sync as revisions arrive.
 		for i, output := range revision.NewValidProofOutputs {
-			err = addAddress(btx, output.UnlockHash, txid)
+			err = store.AppendAddressTxn(output.UnlockHash, height, txIndex, txid)
 			if err != nil {
 				return err
 			}
-			err = addNewOutput(btx, revision.ParentID.StorageProofOutputID(true, i), txid)
+			err = store.PutSiacoinOutput(revision.ParentID.StorageProofOutputID(true, i), txid)
 			if err != nil {
 				return err
 			}
 		}
 		for i, output := range revision.NewMissedProofOutputs {
-			err = addAddress(btx, output.UnlockHash, txid)
+			err = store.AppendAddressTxn(output.UnlockHash, height, txIndex, txid)
 			if err != nil {
 				return err
 			}
-			err = addNewOutput(btx, revision.ParentID.StorageProofOutputID(false, i), txid)
+			err = store.PutSiacoinOutput(revision.ParentID.StorageProofOutputID(false, i), txid)
 			if err != nil {
 				return err
 			}
 		}
 
-		addAddress(btx, revision.NewUnlockHash, txid)
+		err = store.AppendAddressTxn(revision.NewUnlockHash, height, txIndex, txid)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Update the list of storage proofs
 	for _, proof := range tx.StorageProofs {
-		err := addFcProof(btx, proof.ParentID, txid)
+		err := store.SetContractProof(proof.ParentID, txid)
+		if err != nil {
+			return err
+		}
+		err = resolveContractProof(btx, proof.ParentID)
 		if err != nil {
 			return err
 		}
@@ -361,7 +437,7 @@ func (be *BlockExplorer) addTransaction(btx *bolt.Tx, tx types.Transaction) erro
 
 	// Append all the siafund inputs to the modification list
 	for _, input := range tx.SiafundInputs {
-		err := addSiafundInput(btx, input.ParentID, txid)
+		err := store.SetOutputSpender(crypto.Hash(input.ParentID), txid)
 		if err != nil {
 			return err
 		}
@@ -369,16 +445,16 @@ func (be *BlockExplorer) addTransaction(btx *bolt.Tx, tx types.Transaction) erro
 
 	// Handle all the siafund outputs
 	for i, output := range tx.SiafundOutputs {
-		err := addAddress(btx, output.UnlockHash, txid)
+		err := store.AppendAddressTxn(output.UnlockHash, height, txIndex, txid)
 		if err != nil {
 			return err
 		}
-		err = addNewSFOutput(btx, tx.SiafundOutputID(i), txid)
+		err = store.PutSiafundOutput(tx.SiafundOutputID(i), txid)
 		if err != nil {
 			return err
 		}
 
 	}
 
-	return addHashType(btx, txid, hashTransaction)
+	return store.PutHashType(txid, hashTransaction)
 }
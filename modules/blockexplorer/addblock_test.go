@@ -0,0 +1,92 @@
+package blockexplorer
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/types"
+
+	"github.com/boltdb/bolt"
+)
+
+// openAddressTestDB returns a bolt.DB with the buckets addAddress and
+// removeAddress need, removing the underlying file on test cleanup.
+func openAddressTestDB(t *testing.T) *bolt.DB {
+	f, err := ioutil.TempFile("", "blockexplorer-address-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.Remove(f.Name())
+	})
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{"Hashes", "Addresses"} {
+			_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// TestAddressRepeatedInSameTransaction verifies that two addAddress
+// calls for the same address at the same (height, txIndex, txid) -
+// which happens whenever a transaction pays the same UnlockHash more
+// than once - can be undone by two matching removeAddress calls
+// without the second one finding an already-deleted bucket.
+func TestAddressRepeatedInSameTransaction(t *testing.T) {
+	db := openAddressTestDB(t)
+
+	addr := types.UnlockHash{1}
+	height := types.BlockHeight(5)
+	txIndex := 0
+	txid := crypto.Hash{2}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		if err := addAddress(tx, addr, height, txIndex, txid); err != nil {
+			return err
+		}
+		return addAddress(tx, addr, height, txIndex, txid)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := removeAddress(tx, addr, height, txIndex, txid); err != nil {
+			return err
+		}
+		return removeAddress(tx, addr, height, txIndex, txid)
+	})
+	if err != nil {
+		t.Fatalf("apply-then-revert did not return to the starting state: %v", err)
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("Addresses"))
+		if b.Bucket(encoding.Marshal(addr)) != nil {
+			t.Fatal("expected the per-address bucket to be removed once its refcount reached zero")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
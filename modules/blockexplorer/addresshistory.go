@@ -0,0 +1,198 @@
+package blockexplorer
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+
+	"github.com/boltdb/bolt"
+)
+
+// minerPayoutTxIndex is the txIndex used to key an address entry that
+// was touched by a miner payout rather than a transaction, since miner
+// payouts have no transaction of their own to index under.
+const minerPayoutTxIndex = -1
+
+// heightKey returns the big-endian encoding of height, suitable as a
+// bolt key that sorts in height order.
+func heightKey(height types.BlockHeight) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+	return key
+}
+
+// getHeight fetches and decodes the modules.ExplorerBlockData stored
+// under height in the Heights bucket.
+func getHeight(b *bolt.Bucket, height types.BlockHeight) (modules.ExplorerBlockData, error) {
+	var bs modules.ExplorerBlockData
+	bsBytes := b.Get(heightKey(height))
+	if bsBytes == nil {
+		return bs, ErrNilEntry
+	}
+	err := encoding.Unmarshal(bsBytes, &bs)
+	return bs, err
+}
+
+// addressKey returns a key that orders (height, txIndex, txid) tuples
+// in ascending height then txIndex order under bolt's byte-lexical
+// Cursor ordering, so an address's history can be paged with Seek
+// instead of loading it all into memory. txIndex is encoded as an
+// unsigned value, so minerPayoutTxIndex (-1) sorts after every
+// transaction at the same height.
+func addressKey(height types.BlockHeight, txIndex int, txid crypto.Hash) []byte {
+	key := make([]byte, 8+8+crypto.HashSize)
+	binary.BigEndian.PutUint64(key[:8], uint64(height))
+	binary.BigEndian.PutUint64(key[8:16], uint64(txIndex))
+	copy(key[16:], txid[:])
+	return key
+}
+
+// parseAddressKey reverses addressKey.
+func parseAddressKey(key []byte) (height types.BlockHeight, txIndex int, txid crypto.Hash) {
+	height = types.BlockHeight(binary.BigEndian.Uint64(key[:8]))
+	txIndex = int(int64(binary.BigEndian.Uint64(key[8:16])))
+	copy(txid[:], key[16:])
+	return
+}
+
+// txHeightKey returns a key that orders (height, txIndex) pairs in
+// ascending order, used by the TxHeightIndex bucket to support
+// TransactionsByHeightRange.
+func txHeightKey(height types.BlockHeight, txIndex int) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(height))
+	binary.BigEndian.PutUint64(key[8:], uint64(txIndex))
+	return key
+}
+
+// addTxHeightIndex records txid under (height, txIndex) in the
+// TxHeightIndex bucket, undone by removeTxHeightIndex. The bucket is
+// created on first use, the same way the Internal bucket is.
+func addTxHeightIndex(tx *bolt.Tx, height types.BlockHeight, txIndex int, txid crypto.Hash) error {
+	b, err := tx.CreateBucketIfNotExists([]byte("TxHeightIndex"))
+	if err != nil {
+		return err
+	}
+	return b.Put(txHeightKey(height, txIndex), txid[:])
+}
+
+// removeTxHeightIndex undoes addTxHeightIndex.
+func removeTxHeightIndex(tx *bolt.Tx, height types.BlockHeight, txIndex int, txid crypto.Hash) error {
+	b := tx.Bucket([]byte("TxHeightIndex"))
+	if b == nil {
+		return errors.New("bucket TxHeightIndex does not exist")
+	}
+	return b.Delete(txHeightKey(height, txIndex))
+}
+
+// AddressHistory returns up to limit txids that touched addr between
+// startHeight and endHeight (inclusive), in ascending order. Passing
+// the returned nextCursor back in on the following call resumes
+// exactly where the previous page left off; a nil nextCursor means
+// there is nothing more to page through.
+func (be *BlockExplorer) AddressHistory(addr types.UnlockHash, startHeight, endHeight types.BlockHeight, cursor []byte, limit int) ([]crypto.Hash, []byte, error) {
+	var txids []crypto.Hash
+	var nextCursor []byte
+
+	err := be.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("Addresses"))
+		if b == nil {
+			return errors.New("Addresses bucket does not exist")
+		}
+		addrBucket := b.Bucket(encoding.Marshal(addr))
+		if addrBucket == nil {
+			return nil
+		}
+
+		start := cursor
+		if start == nil {
+			start = addressKey(startHeight, 0, crypto.Hash{})
+		}
+
+		c := addrBucket.Cursor()
+		k, _ := c.Seek(start)
+		for k != nil {
+			height, _, txid := parseAddressKey(k)
+			if height > endHeight {
+				k = nil
+				break
+			}
+			if len(txids) >= limit {
+				break
+			}
+			txids = append(txids, txid)
+			k, _ = c.Next()
+		}
+
+		if k != nil {
+			nextCursor = append([]byte{}, k...)
+		}
+		return nil
+	})
+
+	return txids, nextCursor, err
+}
+
+// TransactionsByHeightRange returns the ids of every transaction
+// between startHeight and endHeight (inclusive), in ascending
+// (height, txIndex) order, without scanning the full Transactions
+// bucket.
+func (be *BlockExplorer) TransactionsByHeightRange(startHeight, endHeight types.BlockHeight) ([]crypto.Hash, error) {
+	var txids []crypto.Hash
+
+	err := be.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("TxHeightIndex"))
+		if b == nil {
+			return errors.New("bucket TxHeightIndex does not exist")
+		}
+
+		c := b.Cursor()
+		for k, v := c.Seek(txHeightKey(startHeight, 0)); k != nil; k, v = c.Next() {
+			height := types.BlockHeight(binary.BigEndian.Uint64(k[:8]))
+			if height > endHeight {
+				break
+			}
+			var txid crypto.Hash
+			copy(txid[:], v)
+			txids = append(txids, txid)
+		}
+		return nil
+	})
+
+	return txids, err
+}
+
+// BlocksByHeightRange returns the modules.ExplorerBlockData recorded
+// for every height between startHeight and endHeight (inclusive),
+// without scanning the full Heights bucket.
+func (be *BlockExplorer) BlocksByHeightRange(startHeight, endHeight types.BlockHeight) ([]modules.ExplorerBlockData, error) {
+	var blocks []modules.ExplorerBlockData
+
+	err := be.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("Heights"))
+		if b == nil {
+			return errors.New("bucket Heights does not exist")
+		}
+
+		c := b.Cursor()
+		for k, v := c.Seek(heightKey(startHeight)); k != nil; k, v = c.Next() {
+			height := types.BlockHeight(binary.BigEndian.Uint64(k))
+			if height > endHeight {
+				break
+			}
+			var bs modules.ExplorerBlockData
+			err := encoding.Unmarshal(v, &bs)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, bs)
+		}
+		return nil
+	})
+
+	return blocks, err
+}
@@ -0,0 +1,259 @@
+package blockexplorer
+
+import (
+	"errors"
+
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+
+	"github.com/boltdb/bolt"
+)
+
+// hashrateWindow is the default number of blocks that
+// EstimatedHashrate is averaged over.
+const hashrateWindow = types.BlockHeight(288)
+
+// blockFactsInternal tracks the running totals that BlockFacts
+// accumulates across every block, so that each new record can be
+// derived without rescanning the chain.
+type blockFactsInternal struct {
+	ActiveContractCount uint64
+	ActiveContractCost  types.Currency
+	ActiveContractSize  uint64
+	TotalContractCost   types.Currency
+	TotalContractSize   uint64
+	TotalRevisionVolume uint64
+}
+
+// internalFactsKey is the key under which blockFactsInternal is
+// stored in the Internal bucket.
+var internalFactsKey = []byte("BlockFactsRunning")
+
+// getBlockFactsInternal fetches the running BlockFacts counters,
+// returning a zero value if none have been recorded yet.
+func getBlockFactsInternal(tx *bolt.Tx) (blockFactsInternal, error) {
+	var bfi blockFactsInternal
+	b := tx.Bucket(internalBucket)
+	if b == nil {
+		return bfi, nil
+	}
+
+	factsBytes := b.Get(internalFactsKey)
+	if factsBytes == nil {
+		return bfi, nil
+	}
+	err := encoding.Unmarshal(factsBytes, &bfi)
+	return bfi, err
+}
+
+// putBlockFactsInternal persists the running BlockFacts counters.
+func putBlockFactsInternal(tx *bolt.Tx, bfi blockFactsInternal) error {
+	b, err := tx.CreateBucketIfNotExists(internalBucket)
+	if err != nil {
+		return err
+	}
+	return b.Put(internalFactsKey, encoding.Marshal(bfi))
+}
+
+// addFcFacts updates the running contract counters for a
+// newly-created file contract.
+func addFcFacts(bfi *blockFactsInternal, fc types.FileContract) {
+	bfi.ActiveContractCount++
+	bfi.ActiveContractCost = bfi.ActiveContractCost.Add(fc.Payout)
+	bfi.ActiveContractSize += fc.FileSize
+
+	bfi.TotalContractCost = bfi.TotalContractCost.Add(fc.Payout)
+	bfi.TotalContractSize += fc.FileSize
+}
+
+// addFcRevisionFacts updates the running revision volume counter for
+// a file contract revision.
+func addFcRevisionFacts(bfi *blockFactsInternal, fcr types.FileContractRevision) {
+	bfi.TotalRevisionVolume += fcr.NewFileSize
+}
+
+// removeFcFacts undoes addFcFacts.
+func removeFcFacts(bfi *blockFactsInternal, fc types.FileContract) {
+	bfi.ActiveContractCount--
+	bfi.ActiveContractCost = bfi.ActiveContractCost.Sub(fc.Payout)
+	bfi.ActiveContractSize -= fc.FileSize
+
+	bfi.TotalContractCost = bfi.TotalContractCost.Sub(fc.Payout)
+	bfi.TotalContractSize -= fc.FileSize
+}
+
+// resolveFcFacts updates the running contract counters when a contract
+// leaves the active set by resolving, with or without a storage proof.
+// Unlike removeFcFacts, it leaves the lifetime Total* counters alone:
+// those track every contract ever formed, not just the ones still
+// open.
+func resolveFcFacts(bfi *blockFactsInternal, fc types.FileContract) {
+	bfi.ActiveContractCount--
+	bfi.ActiveContractCost = bfi.ActiveContractCost.Sub(fc.Payout)
+	bfi.ActiveContractSize -= fc.FileSize
+}
+
+// unresolveFcFacts undoes resolveFcFacts, for when a resolution itself
+// gets reverted.
+func unresolveFcFacts(bfi *blockFactsInternal, fc types.FileContract) {
+	bfi.ActiveContractCount++
+	bfi.ActiveContractCost = bfi.ActiveContractCost.Add(fc.Payout)
+	bfi.ActiveContractSize += fc.FileSize
+}
+
+// removeFcRevisionFacts undoes addFcRevisionFacts.
+func removeFcRevisionFacts(bfi *blockFactsInternal, fcr types.FileContractRevision) {
+	bfi.TotalRevisionVolume -= fcr.NewFileSize
+}
+
+// addFactsBucket stores the given BlockFacts under the bucket Facts,
+// keyed by height. The bucket is created on first use, the same way
+// the Internal bucket already is.
+func addFactsBucket(tx *bolt.Tx, height types.BlockHeight, facts modules.BlockFacts) error {
+	b, err := tx.CreateBucketIfNotExists([]byte("Facts"))
+	if err != nil {
+		return err
+	}
+	return putObject(b, height, facts)
+}
+
+// removeFactsBucket undoes addFactsBucket.
+func removeFactsBucket(tx *bolt.Tx, height types.BlockHeight) error {
+	b := tx.Bucket([]byte("Facts"))
+	if b == nil {
+		return errors.New("bucket Facts does not exist")
+	}
+	return b.Delete(encoding.Marshal(height))
+}
+
+// estimatedHashrate returns the estimated network hashrate at the
+// given height, averaged over the trailing hashrateWindow blocks (or
+// fewer, near the start of the chain).
+func estimatedHashrate(tx *bolt.Tx, height types.BlockHeight) (types.Currency, error) {
+	window := hashrateWindow
+	if height < window {
+		window = height
+	}
+	if window == 0 {
+		return types.Currency{}, nil
+	}
+
+	b := tx.Bucket([]byte("Heights"))
+	if b == nil {
+		return types.Currency{}, errors.New("bucket Heights does not exist")
+	}
+
+	var totalDifficulty types.Currency
+	var oldest, newest types.Timestamp
+	for i := types.BlockHeight(0); i < window; i++ {
+		bs, err := getHeight(b, height-i)
+		if err != nil {
+			return types.Currency{}, err
+		}
+		totalDifficulty = totalDifficulty.Add(bs.Target.Difficulty())
+		if i == 0 {
+			newest = bs.Timestamp
+		}
+		oldest = bs.Timestamp
+	}
+
+	timespan := uint64(newest - oldest)
+	if timespan == 0 {
+		timespan = 1
+	}
+
+	return totalDifficulty.Div(types.NewCurrency64(timespan)), nil
+}
+
+// maturityTimestamp returns the timestamp of the block whose outputs
+// mature at the given height, or the zero timestamp if no such block
+// has been processed yet.
+func maturityTimestamp(tx *bolt.Tx, height types.BlockHeight) (types.Timestamp, error) {
+	if height < types.MaturityDelay {
+		return 0, nil
+	}
+
+	b := tx.Bucket([]byte("Heights"))
+	if b == nil {
+		return 0, errors.New("bucket Heights does not exist")
+	}
+
+	bs, err := getHeight(b, height-types.MaturityDelay)
+	if err == ErrNilEntry {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return bs.Timestamp, nil
+}
+
+// buildBlockFacts assembles the modules.BlockFacts record for the
+// given block, combining the running totals in bfi with counts
+// derived from the block itself.
+func buildBlockFacts(tx *bolt.Tx, b types.Block, height types.BlockHeight, blocktarget types.Target, bfi blockFactsInternal) (modules.BlockFacts, error) {
+	hashrate, err := estimatedHashrate(tx, height)
+	if err != nil {
+		return modules.BlockFacts{}, err
+	}
+	maturity, err := maturityTimestamp(tx, height)
+	if err != nil {
+		return modules.BlockFacts{}, err
+	}
+
+	facts := modules.BlockFacts{
+		Height:              height,
+		Difficulty:          blocktarget.Difficulty(),
+		MaturityTimestamp:   maturity,
+		TotalCoins:          types.CalculateNumSiacoins(height),
+		EstimatedHashrate:   hashrate,
+		ActiveContractCount: bfi.ActiveContractCount,
+		ActiveContractCost:  bfi.ActiveContractCost,
+		ActiveContractSize:  bfi.ActiveContractSize,
+		TotalContractCost:   bfi.TotalContractCost,
+		TotalContractSize:   bfi.TotalContractSize,
+		TotalRevisionVolume: bfi.TotalRevisionVolume,
+		TransactionCount:    uint64(len(b.Transactions)),
+	}
+
+	for _, txn := range b.Transactions {
+		facts.SiacoinInputCount += uint64(len(txn.SiacoinInputs))
+		facts.SiacoinOutputCount += uint64(len(txn.SiacoinOutputs))
+		facts.FileContractCount += uint64(len(txn.FileContracts))
+		facts.FileContractRevisionCount += uint64(len(txn.FileContractRevisions))
+		facts.StorageProofCount += uint64(len(txn.StorageProofs))
+		facts.SiafundInputCount += uint64(len(txn.SiafundInputs))
+		facts.SiafundOutputCount += uint64(len(txn.SiafundOutputs))
+		facts.MinerFeeCount += uint64(len(txn.MinerFees))
+	}
+
+	return facts, nil
+}
+
+// LatestBlockFacts returns the BlockFacts recorded for the current
+// blockchain height.
+func (be *BlockExplorer) LatestBlockFacts() (modules.BlockFacts, error) {
+	var facts modules.BlockFacts
+	err := be.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("Facts"))
+		if b == nil {
+			return errors.New("bucket Facts does not exist")
+		}
+		return getObject(b, be.blockchainHeight-1, &facts)
+	})
+	return facts, err
+}
+
+// BlockFacts returns the BlockFacts recorded for the given height.
+func (be *BlockExplorer) BlockFacts(h types.BlockHeight) (modules.BlockFacts, error) {
+	var facts modules.BlockFacts
+	err := be.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("Facts"))
+		if b == nil {
+			return errors.New("bucket Facts does not exist")
+		}
+		return getObject(b, h, &facts)
+	})
+	return facts, err
+}
@@ -0,0 +1,159 @@
+package blockexplorer
+
+import (
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltStore is the only ExplorerStore. Its methods are thin wrappers
+// around the existing bucket-level helpers, so wiring applyBlockDB and
+// revertBlockDB through it changes nothing about what ends up on disk.
+// It wraps the *bolt.Tx passed in by applyBlockDB/revertBlockDB rather
+// than owning a transaction of its own, so that every write a
+// consensus change makes - block, transactions, facts, and all -
+// still commits or rolls back atomically as one bolt transaction.
+type boltStore struct {
+	tx *bolt.Tx
+}
+
+// newBoltStore returns an ExplorerStore that writes through tx.
+func newBoltStore(tx *bolt.Tx) *boltStore {
+	return &boltStore{tx: tx}
+}
+
+func (s *boltStore) PutBlock(height types.BlockHeight, b types.Block, blocktarget types.Target) error {
+	blockStruct := blockData{
+		Block:  b,
+		Height: height,
+	}
+	err := addNewHash(s.tx, "Blocks", hashBlock, crypto.Hash(b.ID()), blockStruct)
+	if err != nil {
+		return err
+	}
+	return addHeight(s.tx, height, explorerBlockSummary(b, blocktarget))
+}
+
+func (s *boltStore) RemoveBlock(height types.BlockHeight, b types.Block) error {
+	err := removeHeight(s.tx, height)
+	if err != nil {
+		return err
+	}
+	return removeNewHash(s.tx, "Blocks", crypto.Hash(b.ID()))
+}
+
+func (s *boltStore) PutTransaction(height types.BlockHeight, txIndex int, blockID types.BlockID, txn types.Transaction) error {
+	err := addNewHash(s.tx, "Transactions", hashTransaction, txn.ID(), txInfo{blockID, txIndex})
+	if err != nil {
+		return err
+	}
+	return addTxHeightIndex(s.tx, height, txIndex, txn.ID())
+}
+
+func (s *boltStore) RemoveTransaction(height types.BlockHeight, txIndex int, txn types.Transaction) error {
+	err := removeTxHeightIndex(s.tx, height, txIndex, txn.ID())
+	if err != nil {
+		return err
+	}
+	return removeNewHash(s.tx, "Transactions", crypto.Hash(txn.ID()))
+}
+
+func (s *boltStore) AppendAddressTxn(addr types.UnlockHash, height types.BlockHeight, txIndex int, txid crypto.Hash) error {
+	return addAddress(s.tx, addr, height, txIndex, txid)
+}
+
+func (s *boltStore) RemoveAddressTxn(addr types.UnlockHash, height types.BlockHeight, txIndex int, txid crypto.Hash) error {
+	return removeAddress(s.tx, addr, height, txIndex, txid)
+}
+
+func (s *boltStore) PutSiacoinOutput(outputID types.SiacoinOutputID, txid crypto.Hash) error {
+	return addNewOutput(s.tx, outputID, txid)
+}
+
+func (s *boltStore) RemoveSiacoinOutput(outputID types.SiacoinOutputID) error {
+	return removeNewOutput(s.tx, outputID)
+}
+
+func (s *boltStore) PutSiafundOutput(outputID types.SiafundOutputID, txid crypto.Hash) error {
+	return addNewSFOutput(s.tx, outputID, txid)
+}
+
+func (s *boltStore) RemoveSiafundOutput(outputID types.SiafundOutputID) error {
+	return removeNewSFOutput(s.tx, outputID)
+}
+
+// SetOutputSpender marks outputID as spent by txid. Siacoin and
+// siafund outputs live in separate buckets, so this tries the siacoin
+// bucket first and falls back to the siafund bucket.
+func (s *boltStore) SetOutputSpender(outputID crypto.Hash, txid crypto.Hash) error {
+	err := addSiacoinInput(s.tx, types.SiacoinOutputID(outputID), txid)
+	if err == ErrNilEntry {
+		return addSiafundInput(s.tx, types.SiafundOutputID(outputID), txid)
+	}
+	return err
+}
+
+// UnsetOutputSpender undoes SetOutputSpender, trying siacoin then
+// siafund the same way SetOutputSpender does.
+func (s *boltStore) UnsetOutputSpender(outputID crypto.Hash) error {
+	err := removeSiacoinInput(s.tx, types.SiacoinOutputID(outputID))
+	if err == ErrNilEntry {
+		return removeSiafundInput(s.tx, types.SiafundOutputID(outputID))
+	}
+	return err
+}
+
+func (s *boltStore) AppendContractRevision(fcid types.FileContractID, txid crypto.Hash) error {
+	return addFcRevision(s.tx, fcid, txid)
+}
+
+// RemoveContractRevision pops the most recently appended revision off
+// of fcid's revision list. txid is accepted to match the shape of the
+// forward call, but isn't needed: revert always undoes revisions in
+// the reverse of the order they were applied, so the entry being
+// popped is always the one txid added.
+func (s *boltStore) RemoveContractRevision(fcid types.FileContractID, txid crypto.Hash) error {
+	return removeFcRevision(s.tx, fcid)
+}
+
+func (s *boltStore) SetContractProof(fcid types.FileContractID, txid crypto.Hash) error {
+	return addFcProof(s.tx, fcid, txid)
+}
+
+func (s *boltStore) ClearContractProof(fcid types.FileContractID) error {
+	return removeFcProof(s.tx, fcid)
+}
+
+func (s *boltStore) PutHashType(hash crypto.Hash, hashType int) error {
+	return addHashType(s.tx, hash, hashType)
+}
+
+func (s *boltStore) RemoveHashType(hash crypto.Hash) error {
+	return removeHashType(s.tx, hash)
+}
+
+func (s *boltStore) PutContract(fcid types.FileContractID, fc types.FileContract) error {
+	return addContract(s.tx, fcid, fc)
+}
+
+func (s *boltStore) RemoveContract(fcid types.FileContractID, fc types.FileContract) error {
+	return removeContract(s.tx, fcid, fc)
+}
+
+func (s *boltStore) PutMaturingPayout(maturityHeight types.BlockHeight, outputID crypto.Hash) error {
+	return addMaturingPayout(s.tx, maturityHeight, outputID)
+}
+
+func (s *boltStore) RemoveMaturingPayout(maturityHeight types.BlockHeight, outputID crypto.Hash) error {
+	return removeMaturingPayout(s.tx, maturityHeight, outputID)
+}
+
+func (s *boltStore) PutBlockFacts(height types.BlockHeight, facts modules.BlockFacts) error {
+	return addFactsBucket(s.tx, height, facts)
+}
+
+func (s *boltStore) RemoveBlockFacts(height types.BlockHeight) error {
+	return removeFactsBucket(s.tx, height)
+}
@@ -0,0 +1,612 @@
+package blockexplorer
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/types"
+
+	"github.com/boltdb/bolt"
+)
+
+// ProofOutcome records which storage-proof outcome actually resolved
+// a file contract's payouts.
+type ProofOutcome int
+
+// The possible ways a file contract can resolve. ProofUnresolved means
+// the contract's proof window has not closed yet.
+const (
+	ProofUnresolved ProofOutcome = iota
+	ProofValid
+	ProofMissed
+	ProofExpired
+)
+
+// fcLifecycle records everything ContractHistory needs about a file
+// contract beyond the txids already tracked in fcInfo: its current
+// proof window and payout outputs (which change on every revision),
+// and how it was ultimately resolved.
+type fcLifecycle struct {
+	WindowStart types.BlockHeight
+	WindowEnd   types.BlockHeight
+	FileSize    uint64
+
+	ValidProofOutputs  []types.SiacoinOutputID
+	MissedProofOutputs []types.SiacoinOutputID
+
+	Outcome         ProofOutcome
+	PayoutOutputIDs []types.SiacoinOutputID
+	MaturityHeight  types.BlockHeight
+}
+
+// ContractHistory is the full lifecycle view of a file contract:
+// every revision in order, the storage proof that was submitted (if
+// any), and the outputs that its resolution pays out, with the height
+// at which those outputs mature.
+type ContractHistory struct {
+	Revisions       []types.FileContractRevision
+	HasStorageProof bool
+	StorageProof    types.StorageProof
+
+	Outcome         ProofOutcome
+	PayoutOutputIDs []types.SiacoinOutputID
+	MaturityHeight  types.BlockHeight
+}
+
+// contractOutputIDs derives the n StorageProofOutputIDs of the given
+// kind (valid or missed) for fcid.
+func contractOutputIDs(fcid types.FileContractID, proofValid bool, n int) []types.SiacoinOutputID {
+	ids := make([]types.SiacoinOutputID, n)
+	for i := range ids {
+		ids[i] = fcid.StorageProofOutputID(proofValid, i)
+	}
+	return ids
+}
+
+// windowEndKey orders (windowEnd, fcid) pairs in ascending windowEnd
+// order, so contracts whose window has just closed can be found with
+// Cursor.Seek instead of a full scan.
+func windowEndKey(windowEnd types.BlockHeight, fcid types.FileContractID) []byte {
+	key := make([]byte, 8+crypto.HashSize)
+	binary.BigEndian.PutUint64(key[:8], uint64(windowEnd))
+	copy(key[8:], fcid[:])
+	return key
+}
+
+// maturingPayoutKey orders (maturityHeight, outputID) pairs in
+// ascending maturityHeight order.
+func maturingPayoutKey(maturityHeight types.BlockHeight, outputID crypto.Hash) []byte {
+	key := make([]byte, 8+crypto.HashSize)
+	binary.BigEndian.PutUint64(key[:8], uint64(maturityHeight))
+	copy(key[8:], outputID[:])
+	return key
+}
+
+// getContractLifecycle fetches the fcLifecycle recorded for fcid.
+func getContractLifecycle(tx *bolt.Tx, fcid types.FileContractID) (fcLifecycle, error) {
+	var lc fcLifecycle
+	b := tx.Bucket([]byte("ContractLifecycle"))
+	if b == nil {
+		return lc, errors.New("bucket ContractLifecycle does not exist")
+	}
+	err := getObject(b, fcid, &lc)
+	return lc, err
+}
+
+// putContractLifecycle persists lc for fcid. The bucket is created on
+// first use, the same way the Internal bucket already is.
+func putContractLifecycle(tx *bolt.Tx, fcid types.FileContractID, lc fcLifecycle) error {
+	b, err := tx.CreateBucketIfNotExists([]byte("ContractLifecycle"))
+	if err != nil {
+		return err
+	}
+	return putObject(b, fcid, lc)
+}
+
+// indexContractWindowEnd records that fcid's proof window closes at
+// windowEnd, so resolveExpiredContracts can find it without scanning
+// every open contract. The bucket is created on first use.
+func indexContractWindowEnd(tx *bolt.Tx, windowEnd types.BlockHeight, fcid types.FileContractID) error {
+	b, err := tx.CreateBucketIfNotExists([]byte("ContractWindowEndIndex"))
+	if err != nil {
+		return err
+	}
+	return b.Put(windowEndKey(windowEnd, fcid), nil)
+}
+
+// unindexContractWindowEnd undoes indexContractWindowEnd.
+func unindexContractWindowEnd(tx *bolt.Tx, windowEnd types.BlockHeight, fcid types.FileContractID) error {
+	b := tx.Bucket([]byte("ContractWindowEndIndex"))
+	if b == nil {
+		return errors.New("bucket ContractWindowEndIndex does not exist")
+	}
+	return b.Delete(windowEndKey(windowEnd, fcid))
+}
+
+// addMaturingPayout records that outputID matures at maturityHeight,
+// whether it came from a miner payout or a resolved file contract. The
+// bucket is created on first use.
+func addMaturingPayout(tx *bolt.Tx, maturityHeight types.BlockHeight, outputID crypto.Hash) error {
+	b, err := tx.CreateBucketIfNotExists([]byte("MaturingPayouts"))
+	if err != nil {
+		return err
+	}
+	return b.Put(maturingPayoutKey(maturityHeight, outputID), nil)
+}
+
+// removeMaturingPayout undoes addMaturingPayout.
+func removeMaturingPayout(tx *bolt.Tx, maturityHeight types.BlockHeight, outputID crypto.Hash) error {
+	b := tx.Bucket([]byte("MaturingPayouts"))
+	if b == nil {
+		return errors.New("bucket MaturingPayouts does not exist")
+	}
+	return b.Delete(maturingPayoutKey(maturityHeight, outputID))
+}
+
+// addContract initializes the lifecycle record for a newly formed
+// file contract and indexes its window end.
+func addContract(tx *bolt.Tx, fcid types.FileContractID, fc types.FileContract) error {
+	lc := fcLifecycle{
+		WindowStart:        fc.WindowStart,
+		WindowEnd:          fc.WindowEnd,
+		FileSize:           fc.FileSize,
+		ValidProofOutputs:  contractOutputIDs(fcid, true, len(fc.ValidProofOutputs)),
+		MissedProofOutputs: contractOutputIDs(fcid, false, len(fc.MissedProofOutputs)),
+		Outcome:            ProofUnresolved,
+	}
+
+	err := putContractLifecycle(tx, fcid, lc)
+	if err != nil {
+		return err
+	}
+	return indexContractWindowEnd(tx, lc.WindowEnd, fcid)
+}
+
+// removeContract undoes addContract.
+func removeContract(tx *bolt.Tx, fcid types.FileContractID, fc types.FileContract) error {
+	err := unindexContractWindowEnd(tx, fc.WindowEnd, fcid)
+	if err != nil {
+		return err
+	}
+	b := tx.Bucket([]byte("ContractLifecycle"))
+	if b == nil {
+		return errors.New("bucket ContractLifecycle does not exist")
+	}
+	return b.Delete(encoding.Marshal(fcid))
+}
+
+// reviseContract updates fcid's lifecycle record to reflect a new
+// revision, re-indexing its window end if the revision moved it.
+func reviseContract(tx *bolt.Tx, fcid types.FileContractID, rev types.FileContractRevision) error {
+	lc, err := getContractLifecycle(tx, fcid)
+	if err != nil {
+		return err
+	}
+
+	err = unindexContractWindowEnd(tx, lc.WindowEnd, fcid)
+	if err != nil {
+		return err
+	}
+
+	lc.WindowStart = rev.NewWindowStart
+	lc.WindowEnd = rev.NewWindowEnd
+	lc.FileSize = rev.NewFileSize
+	lc.ValidProofOutputs = contractOutputIDs(fcid, true, len(rev.NewValidProofOutputs))
+	lc.MissedProofOutputs = contractOutputIDs(fcid, false, len(rev.NewMissedProofOutputs))
+
+	err = putContractLifecycle(tx, fcid, lc)
+	if err != nil {
+		return err
+	}
+	return indexContractWindowEnd(tx, lc.WindowEnd, fcid)
+}
+
+// unreviseContract undoes reviseContract. It must be called after
+// removeFcRevision has already popped the reverted revision's txid off
+// of fi.Revisions, so that the remaining history tells it what the
+// contract's state was immediately before that revision: the contract
+// as formed, if there are no revisions left, or the newest remaining
+// revision otherwise.
+func unreviseContract(tx *bolt.Tx, fcid types.FileContractID) error {
+	fi, err := getFcInfo(tx, fcid)
+	if err != nil {
+		return err
+	}
+
+	lc, err := getContractLifecycle(tx, fcid)
+	if err != nil {
+		return err
+	}
+	err = unindexContractWindowEnd(tx, lc.WindowEnd, fcid)
+	if err != nil {
+		return err
+	}
+
+	if len(fi.Revisions) == 0 {
+		fc, err := lookupContract(tx, fcid, fi.Contract)
+		if err != nil {
+			return err
+		}
+		lc.WindowStart = fc.WindowStart
+		lc.WindowEnd = fc.WindowEnd
+		lc.FileSize = fc.FileSize
+		lc.ValidProofOutputs = contractOutputIDs(fcid, true, len(fc.ValidProofOutputs))
+		lc.MissedProofOutputs = contractOutputIDs(fcid, false, len(fc.MissedProofOutputs))
+	} else {
+		prevRev, err := lookupRevision(tx, fcid, fi.Revisions[len(fi.Revisions)-1])
+		if err != nil {
+			return err
+		}
+		lc.WindowStart = prevRev.NewWindowStart
+		lc.WindowEnd = prevRev.NewWindowEnd
+		lc.FileSize = prevRev.NewFileSize
+		lc.ValidProofOutputs = contractOutputIDs(fcid, true, len(prevRev.NewValidProofOutputs))
+		lc.MissedProofOutputs = contractOutputIDs(fcid, false, len(prevRev.NewMissedProofOutputs))
+	}
+
+	err = putContractLifecycle(tx, fcid, lc)
+	if err != nil {
+		return err
+	}
+	return indexContractWindowEnd(tx, lc.WindowEnd, fcid)
+}
+
+// deactivateContractFacts moves fcid out of the running BlockFacts
+// Active* counters when it resolves, whether by proof or by its
+// window expiring, without touching the lifetime Total* counters.
+func deactivateContractFacts(tx *bolt.Tx, fcid types.FileContractID) error {
+	fi, err := getFcInfo(tx, fcid)
+	if err != nil {
+		return err
+	}
+	fc, err := lookupContract(tx, fcid, fi.Contract)
+	if err != nil {
+		return err
+	}
+
+	bfi, err := getBlockFactsInternal(tx)
+	if err != nil {
+		return err
+	}
+	resolveFcFacts(&bfi, fc)
+	return putBlockFactsInternal(tx, bfi)
+}
+
+// reactivateContractFacts undoes deactivateContractFacts.
+func reactivateContractFacts(tx *bolt.Tx, fcid types.FileContractID) error {
+	fi, err := getFcInfo(tx, fcid)
+	if err != nil {
+		return err
+	}
+	fc, err := lookupContract(tx, fcid, fi.Contract)
+	if err != nil {
+		return err
+	}
+
+	bfi, err := getBlockFactsInternal(tx)
+	if err != nil {
+		return err
+	}
+	unresolveFcFacts(&bfi, fc)
+	return putBlockFactsInternal(tx, bfi)
+}
+
+// resolveContractProof marks fcid as resolved by a submitted storage
+// proof, scheduling its valid proof outputs to mature at WindowEnd +
+// MaturityDelay.
+func resolveContractProof(tx *bolt.Tx, fcid types.FileContractID) error {
+	lc, err := getContractLifecycle(tx, fcid)
+	if err != nil {
+		return err
+	}
+
+	err = unindexContractWindowEnd(tx, lc.WindowEnd, fcid)
+	if err != nil {
+		return err
+	}
+
+	lc.Outcome = ProofValid
+	lc.PayoutOutputIDs = lc.ValidProofOutputs
+	lc.MaturityHeight = lc.WindowEnd + types.MaturityDelay
+
+	err = putContractLifecycle(tx, fcid, lc)
+	if err != nil {
+		return err
+	}
+
+	for _, outputID := range lc.PayoutOutputIDs {
+		err = addMaturingPayout(tx, lc.MaturityHeight, crypto.Hash(outputID))
+		if err != nil {
+			return err
+		}
+	}
+
+	return deactivateContractFacts(tx, fcid)
+}
+
+// unresolveContractProof undoes resolveContractProof.
+func unresolveContractProof(tx *bolt.Tx, fcid types.FileContractID) error {
+	lc, err := getContractLifecycle(tx, fcid)
+	if err != nil {
+		return err
+	}
+
+	for _, outputID := range lc.PayoutOutputIDs {
+		err = removeMaturingPayout(tx, lc.MaturityHeight, crypto.Hash(outputID))
+		if err != nil {
+			return err
+		}
+	}
+
+	lc.Outcome = ProofUnresolved
+	lc.PayoutOutputIDs = nil
+	lc.MaturityHeight = 0
+
+	err = putContractLifecycle(tx, fcid, lc)
+	if err != nil {
+		return err
+	}
+	err = indexContractWindowEnd(tx, lc.WindowEnd, fcid)
+	if err != nil {
+		return err
+	}
+
+	return reactivateContractFacts(tx, fcid)
+}
+
+// resolveExpiredContracts resolves every contract whose proof window
+// closes at height without having received a storage proof, paying
+// out its missed proof outputs. A contract with a FileSize of zero is
+// marked ProofExpired rather than ProofMissed, and likewise pays out
+// its missed proof outputs.
+func (be *BlockExplorer) resolveExpiredContracts(tx *bolt.Tx, height types.BlockHeight) ([]types.FileContractID, error) {
+	b := tx.Bucket([]byte("ContractWindowEndIndex"))
+	if b == nil {
+		// No contract has been indexed yet, so none can expire.
+		return nil, nil
+	}
+
+	var fcids []types.FileContractID
+	c := b.Cursor()
+	prefix := heightKey(height)
+	for k, _ := c.Seek(prefix); k != nil && binary.BigEndian.Uint64(k[:8]) == uint64(height); k, _ = c.Next() {
+		var fcid types.FileContractID
+		copy(fcid[:], k[8:])
+		fcids = append(fcids, fcid)
+	}
+
+	for _, fcid := range fcids {
+		lc, err := getContractLifecycle(tx, fcid)
+		if err != nil {
+			return nil, err
+		}
+
+		err = unindexContractWindowEnd(tx, lc.WindowEnd, fcid)
+		if err != nil {
+			return nil, err
+		}
+
+		lc.Outcome = ProofMissed
+		if lc.FileSize == 0 {
+			lc.Outcome = ProofExpired
+		}
+		lc.PayoutOutputIDs = lc.MissedProofOutputs
+		lc.MaturityHeight = height + types.MaturityDelay
+
+		err = putContractLifecycle(tx, fcid, lc)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, outputID := range lc.PayoutOutputIDs {
+			err = addMaturingPayout(tx, lc.MaturityHeight, crypto.Hash(outputID))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = deactivateContractFacts(tx, fcid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fcids, nil
+}
+
+// unresolveExpiredContracts undoes resolveExpiredContracts for the
+// contracts it resolved at height.
+func (be *BlockExplorer) unresolveExpiredContracts(tx *bolt.Tx, height types.BlockHeight, fcids []types.FileContractID) error {
+	for _, fcid := range fcids {
+		lc, err := getContractLifecycle(tx, fcid)
+		if err != nil {
+			return err
+		}
+
+		for _, outputID := range lc.PayoutOutputIDs {
+			err = removeMaturingPayout(tx, lc.MaturityHeight, crypto.Hash(outputID))
+			if err != nil {
+				return err
+			}
+		}
+
+		lc.Outcome = ProofUnresolved
+		lc.PayoutOutputIDs = nil
+		lc.MaturityHeight = 0
+
+		err = putContractLifecycle(tx, fcid, lc)
+		if err != nil {
+			return err
+		}
+		err = indexContractWindowEnd(tx, lc.WindowEnd, fcid)
+		if err != nil {
+			return err
+		}
+
+		err = reactivateContractFacts(tx, fcid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putResolvedAtHeight records which contracts resolveExpiredContracts
+// resolved at height, so revertBlockDB can find exactly which ones to
+// roll back without re-deriving them. It is called for every applied
+// block, so the bucket it creates on first use exists well before
+// getResolvedAtHeight is ever asked to read from it during a revert.
+func putResolvedAtHeight(tx *bolt.Tx, height types.BlockHeight, fcids []types.FileContractID) error {
+	b, err := tx.CreateBucketIfNotExists([]byte("ResolvedContracts"))
+	if err != nil {
+		return err
+	}
+	if len(fcids) == 0 {
+		return nil
+	}
+	return b.Put(heightKey(height), encoding.Marshal(fcids))
+}
+
+// getResolvedAtHeight undoes putResolvedAtHeight.
+func getResolvedAtHeight(tx *bolt.Tx, height types.BlockHeight) ([]types.FileContractID, error) {
+	b := tx.Bucket([]byte("ResolvedContracts"))
+	if b == nil {
+		return nil, errors.New("bucket ResolvedContracts does not exist")
+	}
+	fcidBytes := b.Get(heightKey(height))
+	if fcidBytes == nil {
+		return nil, nil
+	}
+	var fcids []types.FileContractID
+	err := encoding.Unmarshal(fcidBytes, &fcids)
+	return fcids, err
+}
+
+// removeResolvedAtHeight undoes putResolvedAtHeight's bucket entry.
+func removeResolvedAtHeight(tx *bolt.Tx, height types.BlockHeight) error {
+	b := tx.Bucket([]byte("ResolvedContracts"))
+	if b == nil {
+		return errors.New("bucket ResolvedContracts does not exist")
+	}
+	return b.Delete(heightKey(height))
+}
+
+// lookupContract finds the types.FileContract fcid was created as,
+// inside the transaction identified by txid.
+func lookupContract(tx *bolt.Tx, fcid types.FileContractID, txid crypto.Hash) (types.FileContract, error) {
+	txn, err := lookupTransaction(tx, txid)
+	if err != nil {
+		return types.FileContract{}, err
+	}
+	for i := range txn.FileContracts {
+		if txn.FileContractID(i) == fcid {
+			return txn.FileContracts[i], nil
+		}
+	}
+	return types.FileContract{}, errors.New("file contract not found in indexed transaction")
+}
+
+// lookupRevision finds the FileContractRevision of fcid inside the
+// transaction identified by txid, using the Transactions and Blocks
+// buckets to avoid storing the revision twice.
+func lookupRevision(tx *bolt.Tx, fcid types.FileContractID, txid crypto.Hash) (types.FileContractRevision, error) {
+	txn, err := lookupTransaction(tx, txid)
+	if err != nil {
+		return types.FileContractRevision{}, err
+	}
+	for _, rev := range txn.FileContractRevisions {
+		if rev.ParentID == fcid {
+			return rev, nil
+		}
+	}
+	return types.FileContractRevision{}, errors.New("revision not found in indexed transaction")
+}
+
+// lookupStorageProof finds fcid's StorageProof inside the transaction
+// identified by txid.
+func lookupStorageProof(tx *bolt.Tx, fcid types.FileContractID, txid crypto.Hash) (types.StorageProof, error) {
+	txn, err := lookupTransaction(tx, txid)
+	if err != nil {
+		return types.StorageProof{}, err
+	}
+	for _, proof := range txn.StorageProofs {
+		if proof.ParentID == fcid {
+			return proof, nil
+		}
+	}
+	return types.StorageProof{}, errors.New("storage proof not found in indexed transaction")
+}
+
+// lookupTransaction resolves txid to the full types.Transaction it was
+// recorded under, via the Transactions bucket's txInfo and the Blocks
+// bucket's stored block.
+func lookupTransaction(tx *bolt.Tx, txid crypto.Hash) (types.Transaction, error) {
+	txB := tx.Bucket([]byte("Transactions"))
+	if txB == nil {
+		return types.Transaction{}, errors.New("bucket Transactions does not exist")
+	}
+	var info txInfo
+	err := getObject(txB, txid, &info)
+	if err != nil {
+		return types.Transaction{}, err
+	}
+
+	blocksB := tx.Bucket([]byte("Blocks"))
+	if blocksB == nil {
+		return types.Transaction{}, errors.New("bucket Blocks does not exist")
+	}
+	var bd blockData
+	err = getObject(blocksB, crypto.Hash(info.BlockID), &bd)
+	if err != nil {
+		return types.Transaction{}, err
+	}
+
+	if info.TxIndex < 0 || info.TxIndex >= len(bd.Block.Transactions) {
+		return types.Transaction{}, errors.New("txInfo index out of range for its block")
+	}
+	return bd.Block.Transactions[info.TxIndex], nil
+}
+
+// ContractHistory returns the full lifecycle of fcid: its revisions
+// in order, its storage proof if one was submitted, and how (and to
+// which outputs, maturing at which height) it ultimately resolved.
+func (be *BlockExplorer) ContractHistory(fcid types.FileContractID) (ContractHistory, error) {
+	var history ContractHistory
+
+	err := be.db.View(func(tx *bolt.Tx) error {
+		fi, err := getFcInfo(tx, fcid)
+		if err != nil {
+			return err
+		}
+
+		for _, txid := range fi.Revisions {
+			rev, err := lookupRevision(tx, fcid, txid)
+			if err != nil {
+				return err
+			}
+			history.Revisions = append(history.Revisions, rev)
+		}
+
+		if fi.Proof != (crypto.Hash{}) {
+			proof, err := lookupStorageProof(tx, fcid, fi.Proof)
+			if err != nil {
+				return err
+			}
+			history.StorageProof = proof
+			history.HasStorageProof = true
+		}
+
+		lc, err := getContractLifecycle(tx, fcid)
+		if err != nil {
+			return err
+		}
+		history.Outcome = lc.Outcome
+		history.PayoutOutputIDs = lc.PayoutOutputIDs
+		history.MaturityHeight = lc.MaturityHeight
+		return nil
+	})
+
+	return history, err
+}
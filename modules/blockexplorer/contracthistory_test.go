@@ -0,0 +1,259 @@
+package blockexplorer
+
+import (
+	"testing"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/types"
+
+	"github.com/boltdb/bolt"
+)
+
+// openContractTestDB returns a bolt.DB with every bucket that is read
+// with tx.Bucket (and so must already exist) rather than
+// CreateBucketIfNotExists, removing the underlying file on cleanup.
+func openContractTestDB(t *testing.T) *bolt.DB {
+	db := openAddressTestDB(t)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{"FileContracts", "Transactions", "Blocks"} {
+			_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// TestContractLifecycleApplyRevertRoundTrip exercises a file contract
+// through formation, a revision, and a resolving storage proof, then
+// undoes each step in reverse order, verifying that both the running
+// BlockFacts counters and the ContractLifecycle bucket return to
+// exactly where they started. This is the kind of apply/revert
+// symmetry bug (78edd1d, db6cd32, bfd7d7b, b6701b1) that only shows up
+// once a contract is actually resolved and then reverted.
+func TestContractLifecycleApplyRevertRoundTrip(t *testing.T) {
+	db := openContractTestDB(t)
+
+	contract := types.FileContract{
+		Payout:             types.NewCurrency64(1000),
+		FileSize:           2048,
+		WindowStart:        100,
+		WindowEnd:          110,
+		ValidProofOutputs:  make([]types.SiacoinOutput, 1),
+		MissedProofOutputs: make([]types.SiacoinOutput, 1),
+	}
+	formationTxn := types.Transaction{FileContracts: []types.FileContract{contract}}
+	formationBlock := types.Block{Transactions: []types.Transaction{formationTxn}}
+	fcid := formationTxn.FileContractID(0)
+
+	revision := types.FileContractRevision{
+		ParentID:              fcid,
+		NewWindowStart:        101,
+		NewWindowEnd:          120,
+		NewFileSize:           4096,
+		NewValidProofOutputs:  make([]types.SiacoinOutput, 1),
+		NewMissedProofOutputs: make([]types.SiacoinOutput, 1),
+	}
+	revisionTxn := types.Transaction{FileContractRevisions: []types.FileContractRevision{revision}}
+	revisionBlock := types.Block{Transactions: []types.Transaction{revisionTxn}}
+
+	proof := types.StorageProof{ParentID: fcid}
+	proofTxn := types.Transaction{StorageProofs: []types.StorageProof{proof}}
+	proofBlock := types.Block{Transactions: []types.Transaction{proofTxn}}
+
+	// Apply the formation, the revision, and the resolving proof, in
+	// the same order applyBlockDB/addTransaction would.
+	err := db.Update(func(tx *bolt.Tx) error {
+		err := addNewHash(tx, "Blocks", hashBlock, crypto.Hash(formationBlock.ID()), blockData{Block: formationBlock, Height: 100})
+		if err != nil {
+			return err
+		}
+		err = addNewHash(tx, "Transactions", hashTransaction, formationTxn.ID(), txInfo{formationBlock.ID(), 0})
+		if err != nil {
+			return err
+		}
+		err = addNewHash(tx, "FileContracts", hashFilecontract, crypto.Hash(fcid), fcInfo{Contract: formationTxn.ID()})
+		if err != nil {
+			return err
+		}
+		err = addContract(tx, fcid, contract)
+		if err != nil {
+			return err
+		}
+		bfi, err := getBlockFactsInternal(tx)
+		if err != nil {
+			return err
+		}
+		addFcFacts(&bfi, contract)
+		err = putBlockFactsInternal(tx, bfi)
+		if err != nil {
+			return err
+		}
+
+		err = addNewHash(tx, "Blocks", hashBlock, crypto.Hash(revisionBlock.ID()), blockData{Block: revisionBlock, Height: 101})
+		if err != nil {
+			return err
+		}
+		err = addNewHash(tx, "Transactions", hashTransaction, revisionTxn.ID(), txInfo{revisionBlock.ID(), 0})
+		if err != nil {
+			return err
+		}
+		err = addFcRevision(tx, fcid, revisionTxn.ID())
+		if err != nil {
+			return err
+		}
+		err = reviseContract(tx, fcid, revision)
+		if err != nil {
+			return err
+		}
+		bfi, err = getBlockFactsInternal(tx)
+		if err != nil {
+			return err
+		}
+		addFcRevisionFacts(&bfi, revision)
+		err = putBlockFactsInternal(tx, bfi)
+		if err != nil {
+			return err
+		}
+
+		err = addNewHash(tx, "Blocks", hashBlock, crypto.Hash(proofBlock.ID()), blockData{Block: proofBlock, Height: 102})
+		if err != nil {
+			return err
+		}
+		err = addNewHash(tx, "Transactions", hashTransaction, proofTxn.ID(), txInfo{proofBlock.ID(), 0})
+		if err != nil {
+			return err
+		}
+		err = addFcProof(tx, fcid, proofTxn.ID())
+		if err != nil {
+			return err
+		}
+		return resolveContractProof(tx, fcid)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After the proof resolves the contract, it should have dropped
+	// out of the Active* counters but still count toward the lifetime
+	// Total* ones.
+	err = db.View(func(tx *bolt.Tx) error {
+		bfi, err := getBlockFactsInternal(tx)
+		if err != nil {
+			return err
+		}
+		if bfi.ActiveContractCount != 0 || bfi.ActiveContractSize != 0 {
+			t.Fatalf("expected a resolved contract to leave the Active* counters at zero, got %+v", bfi)
+		}
+		if !bfi.TotalContractCost.Equals(contract.Payout) || bfi.TotalContractSize != contract.FileSize {
+			t.Fatalf("expected the lifetime Total* counters to still reflect the contract, got %+v", bfi)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Undo the proof, the revision, and the formation, in reverse
+	// order.
+	err = db.Update(func(tx *bolt.Tx) error {
+		err := unresolveContractProof(tx, fcid)
+		if err != nil {
+			return err
+		}
+		err = removeFcProof(tx, fcid)
+		if err != nil {
+			return err
+		}
+		err = removeNewHash(tx, "Transactions", crypto.Hash(proofTxn.ID()))
+		if err != nil {
+			return err
+		}
+		err = removeNewHash(tx, "Blocks", crypto.Hash(proofBlock.ID()))
+		if err != nil {
+			return err
+		}
+
+		bfi, err := getBlockFactsInternal(tx)
+		if err != nil {
+			return err
+		}
+		removeFcRevisionFacts(&bfi, revision)
+		err = putBlockFactsInternal(tx, bfi)
+		if err != nil {
+			return err
+		}
+		err = removeFcRevision(tx, fcid)
+		if err != nil {
+			return err
+		}
+		err = unreviseContract(tx, fcid)
+		if err != nil {
+			return err
+		}
+		err = removeNewHash(tx, "Transactions", crypto.Hash(revisionTxn.ID()))
+		if err != nil {
+			return err
+		}
+		err = removeNewHash(tx, "Blocks", crypto.Hash(revisionBlock.ID()))
+		if err != nil {
+			return err
+		}
+
+		bfi, err = getBlockFactsInternal(tx)
+		if err != nil {
+			return err
+		}
+		removeFcFacts(&bfi, contract)
+		err = putBlockFactsInternal(tx, bfi)
+		if err != nil {
+			return err
+		}
+		err = removeContract(tx, fcid, contract)
+		if err != nil {
+			return err
+		}
+		err = removeNewHash(tx, "FileContracts", crypto.Hash(fcid))
+		if err != nil {
+			return err
+		}
+		err = removeNewHash(tx, "Transactions", crypto.Hash(formationTxn.ID()))
+		if err != nil {
+			return err
+		}
+		return removeNewHash(tx, "Blocks", crypto.Hash(formationBlock.ID()))
+	})
+	if err != nil {
+		t.Fatalf("apply-then-revert did not return to the starting state: %v", err)
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		bfi, err := getBlockFactsInternal(tx)
+		if err != nil {
+			return err
+		}
+		zero := types.NewCurrency64(0)
+		if bfi.ActiveContractCount != 0 || !bfi.ActiveContractCost.Equals(zero) || bfi.ActiveContractSize != 0 ||
+			!bfi.TotalContractCost.Equals(zero) || bfi.TotalContractSize != 0 || bfi.TotalRevisionVolume != 0 {
+			t.Fatalf("expected the running BlockFacts counters to return to zero, got %+v", bfi)
+		}
+
+		if _, err := getContractLifecycle(tx, fcid); err != ErrNilEntry {
+			t.Fatalf("expected the contract's lifecycle record to be gone, got err=%v", err)
+		}
+		if _, err := getFcInfo(tx, fcid); err != ErrNilEntry {
+			t.Fatalf("expected the contract's fcInfo to be gone, got err=%v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,420 @@
+package blockexplorer
+
+import (
+	"errors"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/encoding"
+	"github.com/NebulousLabs/Sia/types"
+
+	"github.com/boltdb/bolt"
+)
+
+// removeHashType removes the entry in the Hashes bucket that
+// identifies the given hash, undoing addHashType.
+func removeHashType(tx *bolt.Tx, hash crypto.Hash) error {
+	b := tx.Bucket([]byte("Hashes"))
+	if b == nil {
+		return errors.New("bucket Hashes does not exist")
+	}
+
+	return b.Delete(encoding.Marshal(hash))
+}
+
+// removeAddress undoes one addAddress call for the (height, txIndex,
+// txid) entry in addr's bucket. Since that key's value is a refcount
+// (addAddress may have been called more than once for it, from two
+// outputs in the same transaction paying the same address), this only
+// deletes the key once its refcount reaches zero, and only then checks
+// whether the per-address bucket and its hash type should be removed
+// too.
+func removeAddress(tx *bolt.Tx, addr types.UnlockHash, height types.BlockHeight, txIndex int, txid crypto.Hash) error {
+	b := tx.Bucket([]byte("Addresses"))
+	if b == nil {
+		return errors.New("Addresses bucket does not exist")
+	}
+
+	addrKey := encoding.Marshal(addr)
+	addrBucket := b.Bucket(addrKey)
+	if addrBucket == nil {
+		return errors.New("address bucket out of sync during revert")
+	}
+
+	key := addressKey(height, txIndex, txid)
+	entry := addrBucket.Get(key)
+	if entry == nil {
+		return errors.New("address entry out of sync during revert")
+	}
+	var refCount uint64
+	err := encoding.Unmarshal(entry, &refCount)
+	if err != nil {
+		return err
+	}
+
+	if refCount > 1 {
+		refCount--
+		return addrBucket.Put(key, encoding.Marshal(refCount))
+	}
+
+	err = addrBucket.Delete(key)
+	if err != nil {
+		return err
+	}
+
+	c := addrBucket.Cursor()
+	if k, _ := c.First(); k != nil {
+		return nil
+	}
+
+	err = b.DeleteBucket(addrKey)
+	if err != nil {
+		return err
+	}
+	return removeHashType(tx, crypto.Hash(addr))
+}
+
+// removeSiacoinInput clears the InputTx recorded on an existing
+// outputTransactions struct, undoing addSiacoinInput.
+func removeSiacoinInput(tx *bolt.Tx, outputID types.SiacoinOutputID) error {
+	b := tx.Bucket([]byte("SiacoinOutputs"))
+	if b == nil {
+		return errors.New("bucket SiacoinOutputs does not exist")
+	}
+
+	var ot outputTransactions
+	err := getObject(b, outputID, &ot)
+	if err != nil {
+		return err
+	}
+
+	ot.InputTx = crypto.Hash{}
+
+	return putObject(b, outputID, ot)
+}
+
+// removeSiafundInput does the same thing as removeSiacoinInput except
+// with siafunds.
+func removeSiafundInput(tx *bolt.Tx, outputID types.SiafundOutputID) error {
+	b := tx.Bucket([]byte("SiafundOutputs"))
+	if b == nil {
+		return errors.New("bucket SiafundOutputs does not exist")
+	}
+
+	var ot outputTransactions
+	err := getObject(b, outputID, &ot)
+	if err != nil {
+		return err
+	}
+
+	ot.InputTx = crypto.Hash{}
+
+	return putObject(b, outputID, ot)
+}
+
+// removeFcRevision pops the most recently appended revision txid off
+// of the given contract's revision list, undoing addFcRevision.
+func removeFcRevision(tx *bolt.Tx, fcid types.FileContractID) error {
+	b := tx.Bucket([]byte("FileContracts"))
+	if b == nil {
+		return errors.New("bucket FileContracts does not exist")
+	}
+
+	var fi fcInfo
+	err := getObject(b, fcid, &fi)
+	if err != nil {
+		return err
+	}
+
+	if len(fi.Revisions) == 0 {
+		return errors.New("contract revision list out of sync during revert")
+	}
+	fi.Revisions = fi.Revisions[:len(fi.Revisions)-1]
+
+	return putObject(b, fcid, fi)
+}
+
+// removeFcProof clears the storage proof txid recorded on a contract,
+// undoing addFcProof.
+func removeFcProof(tx *bolt.Tx, fcid types.FileContractID) error {
+	b := tx.Bucket([]byte("FileContracts"))
+	if b == nil {
+		return errors.New("bucket FileContracts does not exist")
+	}
+
+	var fi fcInfo
+	err := getObject(b, fcid, &fi)
+	if err != nil {
+		return err
+	}
+
+	fi.Proof = crypto.Hash{}
+
+	return putObject(b, fcid, fi)
+}
+
+// removeNewHash undoes addNewHash, deleting the hash's entry from the
+// named bucket along with its entry in the Hashes bucket.
+func removeNewHash(tx *bolt.Tx, bucketName string, hash crypto.Hash) error {
+	b := tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return errors.New("bucket does not exist: " + bucketName)
+	}
+	err := b.Delete(encoding.Marshal(hash))
+	if err != nil {
+		return err
+	}
+
+	return removeHashType(tx, hash)
+}
+
+// removeNewOutput undoes addNewOutput.
+func removeNewOutput(tx *bolt.Tx, outputID types.SiacoinOutputID) error {
+	return removeNewHash(tx, "SiacoinOutputs", crypto.Hash(outputID))
+}
+
+// removeNewSFOutput undoes addNewSFOutput.
+func removeNewSFOutput(tx *bolt.Tx, outputID types.SiafundOutputID) error {
+	return removeNewHash(tx, "SiafundOutputs", crypto.Hash(outputID))
+}
+
+// removeHeight undoes addHeight.
+func removeHeight(tx *bolt.Tx, height types.BlockHeight) error {
+	b := tx.Bucket([]byte("Heights"))
+	if b == nil {
+		return errors.New("bucket Heights does not exist")
+	}
+
+	return b.Delete(heightKey(height))
+}
+
+// revertBlockDB undoes what applyBlockDB did for the given block. It
+// undoes the transactions and then the miner payouts, each in reverse
+// order, then the block's facts and the contracts that expired at its
+// height, and finally the block's own entries.
+func (be *BlockExplorer) revertBlockDB(tx *bolt.Tx, b types.Block) error {
+	store := newBoltStore(tx)
+
+	// Remove each transaction, in reverse order.
+	for i := len(b.Transactions) - 1; i >= 0; i-- {
+		err := be.removeTransaction(store, tx, be.blockchainHeight, i, b.Transactions[i])
+		if err != nil {
+			return err
+		}
+		err = store.RemoveTransaction(be.blockchainHeight, i, b.Transactions[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	// Remove the miner payouts, in reverse order.
+	for i := len(b.MinerPayouts) - 1; i >= 0; i-- {
+		err := store.RemoveMaturingPayout(be.blockchainHeight+types.MaturityDelay, crypto.Hash(b.MinerPayoutID(i)))
+		if err != nil {
+			return err
+		}
+		err = store.RemoveSiacoinOutput(b.MinerPayoutID(i))
+		if err != nil {
+			return err
+		}
+		err = store.RemoveAddressTxn(b.MinerPayouts[i].UnlockHash, be.blockchainHeight, minerPayoutTxIndex, crypto.Hash(b.ID()))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := be.removeBlockFacts(store, tx, b)
+	if err != nil {
+		return err
+	}
+
+	resolved, err := getResolvedAtHeight(tx, be.blockchainHeight)
+	if err != nil {
+		return err
+	}
+	err = be.unresolveExpiredContracts(tx, be.blockchainHeight, resolved)
+	if err != nil {
+		return err
+	}
+	err = removeResolvedAtHeight(tx, be.blockchainHeight)
+	if err != nil {
+		return err
+	}
+
+	err = removeHeight(tx, be.blockchainHeight)
+	if err != nil {
+		return err
+	}
+
+	return store.RemoveBlock(be.blockchainHeight, b)
+}
+
+// removeBlockFacts undoes addBlockFacts, rolling the running
+// BlockFacts counters back and deleting the Facts entry for the
+// block's height.
+func (be *BlockExplorer) removeBlockFacts(store ExplorerStore, tx *bolt.Tx, b types.Block) error {
+	bfi, err := getBlockFactsInternal(tx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(b.Transactions) - 1; i >= 0; i-- {
+		txn := b.Transactions[i]
+		for j := len(txn.FileContractRevisions) - 1; j >= 0; j-- {
+			removeFcRevisionFacts(&bfi, txn.FileContractRevisions[j])
+		}
+		for j := len(txn.FileContracts) - 1; j >= 0; j-- {
+			removeFcFacts(&bfi, txn.FileContracts[j])
+		}
+	}
+
+	err = store.RemoveBlockFacts(be.blockchainHeight)
+	if err != nil {
+		return err
+	}
+
+	return putBlockFactsInternal(tx, bfi)
+}
+
+// removeTransaction undoes everything that addTransaction did for the
+// given transaction, in the reverse order that it was originally
+// applied. btx is still needed alongside store for the
+// contract-lifecycle bookkeeping (unreviseContract, unresolveContractProof)
+// that falls outside ExplorerStore's write set.
+func (be *BlockExplorer) removeTransaction(store ExplorerStore, btx *bolt.Tx, height types.BlockHeight, txIndex int, tx types.Transaction) error {
+	txid := tx.ID()
+
+	// Undo the siafund outputs.
+	for i := len(tx.SiafundOutputs) - 1; i >= 0; i-- {
+		err := store.RemoveSiafundOutput(tx.SiafundOutputID(i))
+		if err != nil {
+			return err
+		}
+		err = store.RemoveAddressTxn(tx.SiafundOutputs[i].UnlockHash, height, txIndex, txid)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Undo the siafund inputs.
+	for i := len(tx.SiafundInputs) - 1; i >= 0; i-- {
+		err := store.UnsetOutputSpender(crypto.Hash(tx.SiafundInputs[i].ParentID))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Undo the storage proofs.
+	for i := len(tx.StorageProofs) - 1; i >= 0; i-- {
+		err := unresolveContractProof(btx, tx.StorageProofs[i].ParentID)
+		if err != nil {
+			return err
+		}
+		err = store.ClearContractProof(tx.StorageProofs[i].ParentID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Undo the file contract revisions.
+	for i := len(tx.FileContractRevisions) - 1; i >= 0; i-- {
+		revision := tx.FileContractRevisions[i]
+
+		err := store.RemoveAddressTxn(revision.NewUnlockHash, height, txIndex, txid)
+		if err != nil {
+			return err
+		}
+
+		for j := len(revision.NewMissedProofOutputs) - 1; j >= 0; j-- {
+			err := store.RemoveSiacoinOutput(revision.ParentID.StorageProofOutputID(false, j))
+			if err != nil {
+				return err
+			}
+			err = store.RemoveAddressTxn(revision.NewMissedProofOutputs[j].UnlockHash, height, txIndex, txid)
+			if err != nil {
+				return err
+			}
+		}
+		for j := len(revision.NewValidProofOutputs) - 1; j >= 0; j-- {
+			err := store.RemoveSiacoinOutput(revision.ParentID.StorageProofOutputID(true, j))
+			if err != nil {
+				return err
+			}
+			err = store.RemoveAddressTxn(revision.NewValidProofOutputs[j].UnlockHash, height, txIndex, txid)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = store.RemoveContractRevision(revision.ParentID, txid)
+		if err != nil {
+			return err
+		}
+		err = unreviseContract(btx, revision.ParentID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Undo each file contract.
+	for i := len(tx.FileContracts) - 1; i >= 0; i-- {
+		contract := tx.FileContracts[i]
+		fcid := tx.FileContractID(i)
+
+		err := store.RemoveAddressTxn(contract.UnlockHash, height, txIndex, txid)
+		if err != nil {
+			return err
+		}
+
+		for j := len(contract.MissedProofOutputs) - 1; j >= 0; j-- {
+			err = store.RemoveSiacoinOutput(fcid.StorageProofOutputID(false, j))
+			if err != nil {
+				return err
+			}
+			err = store.RemoveAddressTxn(contract.MissedProofOutputs[j].UnlockHash, height, txIndex, txid)
+			if err != nil {
+				return err
+			}
+		}
+		for j := len(contract.ValidProofOutputs) - 1; j >= 0; j-- {
+			err = store.RemoveSiacoinOutput(fcid.StorageProofOutputID(true, j))
+			if err != nil {
+				return err
+			}
+			err = store.RemoveAddressTxn(contract.ValidProofOutputs[j].UnlockHash, height, txIndex, txid)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = store.RemoveContract(fcid, contract)
+		if err != nil {
+			return err
+		}
+		err = removeNewHash(btx, "FileContracts", crypto.Hash(fcid))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Undo the transaction outputs.
+	for i := len(tx.SiacoinOutputs) - 1; i >= 0; i-- {
+		err := store.RemoveSiacoinOutput(tx.SiacoinOutputID(i))
+		if err != nil {
+			return err
+		}
+		err = store.RemoveAddressTxn(tx.SiacoinOutputs[i].UnlockHash, height, txIndex, txid)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Undo the inputs.
+	for i := len(tx.SiacoinInputs) - 1; i >= 0; i-- {
+		err := store.UnsetOutputSpender(crypto.Hash(tx.SiacoinInputs[i].ParentID))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package blockexplorer
+
+import (
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+)
+
+// ExplorerStore is the persistence layer applyBlockDB and revertBlockDB
+// write through, rather than touching bolt buckets directly. boltStore
+// is the only implementation; there is no relational backend. The
+// interface names the write set applyBlockDB/revertBlockDB actually
+// need, instead of leaving every caller to reach for the right bucket
+// name by hand.
+//
+// Every Put/Append/Set method has a matching Remove/Unset/Clear that
+// undoes it, since revertBlockDB needs to undo an apply exactly as
+// applyBlockDB made it.
+type ExplorerStore interface {
+	// PutBlock indexes a block at the given height; RemoveBlock undoes it.
+	PutBlock(height types.BlockHeight, b types.Block, blocktarget types.Target) error
+	RemoveBlock(height types.BlockHeight, b types.Block) error
+
+	// PutTransaction indexes a transaction at its position in the
+	// chain; RemoveTransaction undoes it.
+	PutTransaction(height types.BlockHeight, txIndex int, blockID types.BlockID, txn types.Transaction) error
+	RemoveTransaction(height types.BlockHeight, txIndex int, txn types.Transaction) error
+
+	// AppendAddressTxn records that txid touched addr at the given
+	// position in the chain; RemoveAddressTxn undoes one such record.
+	AppendAddressTxn(addr types.UnlockHash, height types.BlockHeight, txIndex int, txid crypto.Hash) error
+	RemoveAddressTxn(addr types.UnlockHash, height types.BlockHeight, txIndex int, txid crypto.Hash) error
+
+	// PutSiacoinOutput and PutSiafundOutput record a newly created
+	// output; the Remove variants undo them.
+	PutSiacoinOutput(outputID types.SiacoinOutputID, txid crypto.Hash) error
+	RemoveSiacoinOutput(outputID types.SiacoinOutputID) error
+	PutSiafundOutput(outputID types.SiafundOutputID, txid crypto.Hash) error
+	RemoveSiafundOutput(outputID types.SiafundOutputID) error
+
+	// SetOutputSpender records that a siacoin or siafund output was
+	// spent by txid; UnsetOutputSpender undoes it.
+	SetOutputSpender(outputID crypto.Hash, txid crypto.Hash) error
+	UnsetOutputSpender(outputID crypto.Hash) error
+
+	// AppendContractRevision records that txid revised fcid;
+	// RemoveContractRevision undoes it.
+	AppendContractRevision(fcid types.FileContractID, txid crypto.Hash) error
+	RemoveContractRevision(fcid types.FileContractID, txid crypto.Hash) error
+
+	// SetContractProof records that txid is fcid's storage proof;
+	// ClearContractProof undoes it.
+	SetContractProof(fcid types.FileContractID, txid crypto.Hash) error
+	ClearContractProof(fcid types.FileContractID) error
+
+	// PutHashType records what kind of object hash identifies;
+	// RemoveHashType undoes it.
+	PutHashType(hash crypto.Hash, hashType int) error
+	RemoveHashType(hash crypto.Hash) error
+
+	// PutContract records a newly formed contract's lifecycle state;
+	// RemoveContract undoes it.
+	PutContract(fcid types.FileContractID, fc types.FileContract) error
+	RemoveContract(fcid types.FileContractID, fc types.FileContract) error
+
+	// PutMaturingPayout records that outputID matures at
+	// maturityHeight; RemoveMaturingPayout undoes it.
+	PutMaturingPayout(maturityHeight types.BlockHeight, outputID crypto.Hash) error
+	RemoveMaturingPayout(maturityHeight types.BlockHeight, outputID crypto.Hash) error
+
+	// PutBlockFacts records the BlockFacts computed for height;
+	// RemoveBlockFacts undoes it.
+	PutBlockFacts(height types.BlockHeight, facts modules.BlockFacts) error
+	RemoveBlockFacts(height types.BlockHeight) error
+}
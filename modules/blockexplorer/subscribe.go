@@ -0,0 +1,242 @@
+package blockexplorer
+
+import (
+	"sync/atomic"
+
+	"github.com/NebulousLabs/Sia/crypto"
+	"github.com/NebulousLabs/Sia/modules"
+	"github.com/NebulousLabs/Sia/types"
+
+	"github.com/boltdb/bolt"
+)
+
+// subscriberBufferSize bounds how many updates a subscriber may fall
+// behind by before it is treated as a slow consumer and disconnected.
+const subscriberBufferSize = 50
+
+// ExplorerUpdate is sent to every ExplorerSubscriber whenever the
+// explorer processes a consensus change. It carries the raw blocks
+// alongside diffs derived from them, so a subscriber does not need to
+// re-parse every block itself to know what changed.
+type ExplorerUpdate struct {
+	AppliedBlocks  []types.Block
+	RevertedBlocks []types.Block
+
+	// NewHashes are the block and transaction ids introduced by
+	// AppliedBlocks, followed by those removed by RevertedBlocks.
+	NewHashes []crypto.Hash
+
+	// AddressDeltas maps every address touched by this update to the
+	// txids (or block ids, for miner payouts) that touched it.
+	AddressDeltas map[types.UnlockHash][]crypto.Hash
+
+	// ContractDeltas maps every file contract touched by this update
+	// to its resulting fcInfo, so a subscriber can see the contract's
+	// state without a follow-up lookup. A contract that has no fcInfo
+	// after the update (because its creation was reverted) is omitted.
+	ContractDeltas map[types.FileContractID]fcInfo
+
+	// Synced is true if the explorer is caught up to the consensus
+	// set's current tip as of this update.
+	Synced bool
+}
+
+// ExplorerSubscriber is implemented by types that want to be notified
+// every time the block explorer processes a consensus change, such as
+// websocket push handlers or external indexers.
+type ExplorerSubscriber interface {
+	ReceiveExplorerUpdate(update ExplorerUpdate)
+}
+
+// explorerSubscription is the explorer's bookkeeping for a single
+// subscriber: a bounded channel of pending updates, drained by its own
+// goroutine, plus whether the most recent update that subscriber
+// processed was Synced.
+type explorerSubscription struct {
+	updates chan ExplorerUpdate
+	synced  uint32
+}
+
+// Subscribe registers sub to receive every future ExplorerUpdate.
+// Updates are delivered on a bounded per-subscriber channel; if sub
+// falls far enough behind that the channel fills up, it is
+// disconnected rather than blocking the explorer.
+func (be *BlockExplorer) Subscribe(sub ExplorerSubscriber) {
+	es := &explorerSubscription{
+		updates: make(chan ExplorerUpdate, subscriberBufferSize),
+	}
+
+	be.subscriptionsMu.Lock()
+	be.subscriptions[sub] = es
+	be.subscriptionsMu.Unlock()
+
+	go func() {
+		for update := range es.updates {
+			sub.ReceiveExplorerUpdate(update)
+			synced := uint32(0)
+			if update.Synced {
+				synced = 1
+			}
+			atomic.StoreUint32(&es.synced, synced)
+		}
+	}()
+}
+
+// Unsubscribe stops sub from receiving further updates.
+func (be *BlockExplorer) Unsubscribe(sub ExplorerSubscriber) {
+	be.subscriptionsMu.Lock()
+	defer be.subscriptionsMu.Unlock()
+
+	es, exists := be.subscriptions[sub]
+	if !exists {
+		return
+	}
+	delete(be.subscriptions, sub)
+	close(es.updates)
+}
+
+// Synchronized returns whether the most recent update sub has
+// processed left the explorer caught up to the consensus set's tip.
+// It returns false
+// for a sub that was never subscribed or has since been disconnected.
+func (be *BlockExplorer) Synchronized(sub ExplorerSubscriber) bool {
+	be.subscriptionsMu.Lock()
+	es, exists := be.subscriptions[sub]
+	be.subscriptionsMu.Unlock()
+	if !exists {
+		return false
+	}
+	return atomic.LoadUint32(&es.synced) == 1
+}
+
+// notifySubscribers delivers update to every subscriber, disconnecting
+// any whose buffer is full instead of blocking on them.
+func (be *BlockExplorer) notifySubscribers(update ExplorerUpdate) {
+	be.subscriptionsMu.Lock()
+	defer be.subscriptionsMu.Unlock()
+
+	dispatchUpdate(be.subscriptions, update)
+}
+
+// dispatchUpdate delivers update to each subscription's bounded
+// channel, disconnecting (removing from subscriptions and closing its
+// channel) any whose buffer is already full instead of blocking on
+// them. Callers must hold subscriptionsMu.
+func dispatchUpdate(subscriptions map[ExplorerSubscriber]*explorerSubscription, update ExplorerUpdate) {
+	for sub, es := range subscriptions {
+		select {
+		case es.updates <- update:
+		default:
+			delete(subscriptions, sub)
+			close(es.updates)
+		}
+	}
+}
+
+// getFcInfo fetches the fcInfo recorded for fcid, returning
+// ErrNilEntry if the contract has no entry (for example, immediately
+// after its creation was reverted).
+func getFcInfo(tx *bolt.Tx, fcid types.FileContractID) (fcInfo, error) {
+	var fi fcInfo
+	b := tx.Bucket([]byte("FileContracts"))
+	if b == nil {
+		return fi, ErrNilEntry
+	}
+	err := getObject(b, fcid, &fi)
+	return fi, err
+}
+
+// buildExplorerUpdate derives the NewHashes, AddressDeltas, and
+// ContractDeltas for cc from the blocks it applies and reverts,
+// reading contract state from tx so that ContractDeltas reflects each
+// contract's state after this change has been applied.
+func (be *BlockExplorer) buildExplorerUpdate(tx *bolt.Tx, cc modules.ConsensusChange) (ExplorerUpdate, error) {
+	update := ExplorerUpdate{
+		AppliedBlocks:  cc.AppliedBlocks,
+		RevertedBlocks: cc.RevertedBlocks,
+		AddressDeltas:  make(map[types.UnlockHash][]crypto.Hash),
+		ContractDeltas: make(map[types.FileContractID]fcInfo),
+		Synced:         cc.Synced,
+	}
+
+	addDelta := func(addr types.UnlockHash, id crypto.Hash) {
+		update.AddressDeltas[addr] = append(update.AddressDeltas[addr], id)
+	}
+	addContractDelta := func(fcid types.FileContractID) error {
+		fi, err := getFcInfo(tx, fcid)
+		if err == ErrNilEntry {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		update.ContractDeltas[fcid] = fi
+		return nil
+	}
+
+	record := func(blocks []types.Block) error {
+		for _, block := range blocks {
+			update.NewHashes = append(update.NewHashes, crypto.Hash(block.ID()))
+			for _, payout := range block.MinerPayouts {
+				addDelta(payout.UnlockHash, crypto.Hash(block.ID()))
+			}
+
+			for _, txn := range block.Transactions {
+				txid := txn.ID()
+				update.NewHashes = append(update.NewHashes, crypto.Hash(txid))
+
+				for _, output := range txn.SiacoinOutputs {
+					addDelta(output.UnlockHash, txid)
+				}
+				for _, output := range txn.SiafundOutputs {
+					addDelta(output.UnlockHash, txid)
+				}
+				for i, contract := range txn.FileContracts {
+					fcid := txn.FileContractID(i)
+					addDelta(contract.UnlockHash, txid)
+					for _, output := range contract.ValidProofOutputs {
+						addDelta(output.UnlockHash, txid)
+					}
+					for _, output := range contract.MissedProofOutputs {
+						addDelta(output.UnlockHash, txid)
+					}
+					err := addContractDelta(fcid)
+					if err != nil {
+						return err
+					}
+				}
+				for _, revision := range txn.FileContractRevisions {
+					addDelta(revision.NewUnlockHash, txid)
+					for _, output := range revision.NewValidProofOutputs {
+						addDelta(output.UnlockHash, txid)
+					}
+					for _, output := range revision.NewMissedProofOutputs {
+						addDelta(output.UnlockHash, txid)
+					}
+					err := addContractDelta(revision.ParentID)
+					if err != nil {
+						return err
+					}
+				}
+				for _, proof := range txn.StorageProofs {
+					err := addContractDelta(proof.ParentID)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	err := record(cc.AppliedBlocks)
+	if err != nil {
+		return ExplorerUpdate{}, err
+	}
+	err = record(cc.RevertedBlocks)
+	if err != nil {
+		return ExplorerUpdate{}, err
+	}
+
+	return update, nil
+}
@@ -0,0 +1,38 @@
+package blockexplorer
+
+import "testing"
+
+// fakeSubscriber is an ExplorerSubscriber that does nothing with the
+// updates it receives; dispatchUpdate only needs it as a map key.
+type fakeSubscriber struct{}
+
+func (*fakeSubscriber) ReceiveExplorerUpdate(update ExplorerUpdate) {}
+
+// TestDispatchUpdateDisconnectsSlowSubscriber verifies that a
+// subscriber whose channel is full gets dropped - removed from the
+// subscriptions map and its channel closed - rather than blocking the
+// rest of dispatchUpdate's callers.
+func TestDispatchUpdateDisconnectsSlowSubscriber(t *testing.T) {
+	sub := &fakeSubscriber{}
+	es := &explorerSubscription{updates: make(chan ExplorerUpdate, 1)}
+	subscriptions := map[ExplorerSubscriber]*explorerSubscription{sub: es}
+
+	dispatchUpdate(subscriptions, ExplorerUpdate{})
+	if _, exists := subscriptions[sub]; !exists {
+		t.Fatal("subscriber should not be disconnected while its buffer still has room")
+	}
+
+	// The buffer is now full; this send should disconnect sub instead
+	// of blocking.
+	dispatchUpdate(subscriptions, ExplorerUpdate{})
+	if _, exists := subscriptions[sub]; exists {
+		t.Fatal("expected the slow subscriber to be disconnected once its buffer filled")
+	}
+
+	if _, ok := <-es.updates; !ok {
+		t.Fatal("expected the buffered update to still be readable")
+	}
+	if _, ok := <-es.updates; ok {
+		t.Fatal("expected the subscriber's channel to be closed after disconnecting")
+	}
+}
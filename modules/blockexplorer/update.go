@@ -0,0 +1,98 @@
+package blockexplorer
+
+import (
+	"github.com/NebulousLabs/Sia/build"
+	"github.com/NebulousLabs/Sia/modules"
+
+	"github.com/boltdb/bolt"
+)
+
+// internalBucket is the bolt bucket used to store explorer metadata
+// that is not itself part of the indexed chain data, such as the
+// ConsensusChangeID the explorer has most recently processed.
+var internalBucket = []byte("Internal")
+
+// consensusChangeIDKey is the key under which the most recently
+// processed modules.ConsensusChangeID is stored in the Internal
+// bucket, so the explorer can resume its subscription across restarts.
+var consensusChangeIDKey = []byte("RecentChange")
+
+// getConsensusChangeID returns the most recently processed
+// ConsensusChangeID, or modules.ConsensusChangeBeginning if the
+// explorer has never processed a change.
+func getConsensusChangeID(tx *bolt.Tx) (cc modules.ConsensusChangeID) {
+	b := tx.Bucket(internalBucket)
+	if b == nil {
+		return modules.ConsensusChangeBeginning
+	}
+
+	ccBytes := b.Get(consensusChangeIDKey)
+	if ccBytes == nil {
+		return modules.ConsensusChangeBeginning
+	}
+	copy(cc[:], ccBytes)
+	return cc
+}
+
+// putConsensusChangeID records the ConsensusChangeID that the explorer
+// has just finished processing.
+func putConsensusChangeID(tx *bolt.Tx, cc modules.ConsensusChangeID) error {
+	b, err := tx.CreateBucketIfNotExists(internalBucket)
+	if err != nil {
+		return err
+	}
+	return b.Put(consensusChangeIDKey, cc[:])
+}
+
+// ProcessConsensusChange follows the most recent changes to the
+// consensus set, including crafting a block explorer.
+func (be *BlockExplorer) ProcessConsensusChange(cc modules.ConsensusChange) {
+	tx, err := be.db.Begin(true)
+	if err != nil {
+		build.Critical("blockexplorer: failed to start db transaction:", err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Revert the blocks that are no longer part of the current
+	// path, most recent first, undoing every index mutation they
+	// made.
+	for _, block := range cc.RevertedBlocks {
+		be.blockchainHeight--
+		err = be.revertBlockDB(tx, block)
+		if err != nil {
+			build.Critical("blockexplorer: failed to revert block:", err)
+			return
+		}
+	}
+
+	// Apply the blocks that are now part of the current path.
+	for _, block := range cc.AppliedBlocks {
+		err = be.applyBlockDB(tx, block)
+		if err != nil {
+			build.Critical("blockexplorer: failed to apply block:", err)
+			return
+		}
+		be.blockchainHeight++
+	}
+
+	err = putConsensusChangeID(tx, cc.ID)
+	if err != nil {
+		build.Critical("blockexplorer: failed to update consensus change id:", err)
+		return
+	}
+
+	update, err := be.buildExplorerUpdate(tx, cc)
+	if err != nil {
+		build.Critical("blockexplorer: failed to build explorer update:", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		build.Critical("blockexplorer: failed to commit consensus change:", err)
+		return
+	}
+
+	be.notifySubscribers(update)
+}